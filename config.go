@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileConfig is the shape of a --config YAML file: a set of independent
+// watchers, each uploading to its own webhook, plus a library of image
+// processing pipelines they can refer to by name.
+type FileConfig struct {
+	Watchers  []WatcherConfig           `yaml:"watchers"`
+	Pipelines map[string]PipelineConfig `yaml:"processor_pipelines"`
+}
+
+// WatcherConfig describes one directory to watch and where its uploads go.
+type WatcherConfig struct {
+	Path              string      `yaml:"path"`
+	WebhookURL        string      `yaml:"webhook_url"`
+	Username          string      `yaml:"username"`
+	AvatarURL         string      `yaml:"avatar_url"`
+	WatchInterval     int         `yaml:"watch_interval"`
+	Extensions        []string    `yaml:"extensions"`
+	ExcludeGlobs      []string    `yaml:"exclude_globs"`
+	ContentTemplate   string      `yaml:"content_template"`
+	ProcessorPipeline string      `yaml:"processor_pipeline"`
+	Embed             EmbedConfig `yaml:"embed"`
+}
+
+// EmbedConfig is the YAML form of the single rich embed a watcher can send
+// alongside every upload, mirroring the --embed-* CLI flags.
+type EmbedConfig struct {
+	Title       string `yaml:"title"`
+	Description string `yaml:"description"`
+	URL         string `yaml:"url"`
+	Color       int    `yaml:"color"`
+}
+
+// PipelineConfig is the YAML form of an image processing Pipeline, wired up
+// the same way buildPipeline wires CLI flags. WatermarkOpacity is a pointer
+// so an explicit `watermark_opacity: 0` (fully invisible) can be told apart
+// from the field being omitted entirely.
+type PipelineConfig struct {
+	MaxWidth         int      `yaml:"max_width"`
+	MaxHeight        int      `yaml:"max_height"`
+	Watermark        string   `yaml:"watermark"`
+	WatermarkOpacity *float64 `yaml:"watermark_opacity"`
+	StripEXIF        bool     `yaml:"strip_exif"`
+	ConvertTo        string   `yaml:"convert_to"`
+}
+
+// loadFileConfig reads and parses a --config YAML file.
+func loadFileConfig(path string) (*FileConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var fc FileConfig
+	if err := yaml.Unmarshal(data, &fc); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+	if len(fc.Watchers) == 0 {
+		return nil, fmt.Errorf("%s declares no watchers", path)
+	}
+	return &fc, nil
+}
+
+// watcherConfigs builds the list of per-watcher Configs to run. With no
+// configPath it returns base unchanged as a single synthetic watcher, so the
+// existing CLI flags keep working exactly as before. With configPath set,
+// every field comes from the YAML file instead; base is only consulted for
+// process-wide settings such as the control server port and state file.
+func watcherConfigs(base Config, configPath string) ([]Config, error) {
+	if configPath == "" {
+		return []Config{base}, nil
+	}
+
+	fc, err := loadFileConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	configs := make([]Config, 0, len(fc.Watchers))
+	for _, w := range fc.Watchers {
+		cfg := base
+		cfg.path = w.Path
+		cfg.webhookURL = w.WebhookURL
+		cfg.username = w.Username
+		cfg.avatarURL = w.AvatarURL
+		cfg.extensions = w.Extensions
+		cfg.excludeGlobs = w.ExcludeGlobs
+		cfg.embeds = buildEmbeds(w.Embed.Title, w.Embed.Description, w.Embed.URL, w.Embed.Color)
+
+		if w.WatchInterval > 0 {
+			cfg.watch = w.WatchInterval
+		}
+
+		if w.ContentTemplate != "" {
+			tmpl, err := template.New(w.Path).Parse(w.ContentTemplate)
+			if err != nil {
+				return nil, fmt.Errorf("watcher %s: invalid content_template: %w", w.Path, err)
+			}
+			cfg.contentTemplate = tmpl
+		}
+
+		if w.ProcessorPipeline != "" {
+			pc, ok := fc.Pipelines[w.ProcessorPipeline]
+			if !ok {
+				return nil, fmt.Errorf("watcher %s: processor_pipeline %q not found", w.Path, w.ProcessorPipeline)
+			}
+			opacity := 1.0
+			if pc.WatermarkOpacity != nil {
+				opacity = *pc.WatermarkOpacity
+			}
+			cfg.pipeline = buildPipeline(pc.MaxWidth, pc.MaxHeight, pc.Watermark, fmt.Sprintf("%v", opacity), pc.StripEXIF, pc.ConvertTo)
+		}
+
+		configs = append(configs, cfg)
+	}
+
+	return configs, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeYAML(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "dau.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestWatcherConfigsNoFileReturnsBaseAsSingleWatcher(t *testing.T) {
+	base := Config{path: "/tmp/shots", webhookURL: "http://example.com/hook"}
+	configs, err := watcherConfigs(base, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 1 || configs[0].path != base.path || configs[0].webhookURL != base.webhookURL {
+		t.Errorf("watcherConfigs(base, \"\") = %+v, want [base]", configs)
+	}
+}
+
+func TestWatcherConfigsLoadsMultipleWatchers(t *testing.T) {
+	path := writeYAML(t, `
+watchers:
+  - path: /tmp/screenshots
+    webhook_url: http://example.com/a
+    extensions: [".png"]
+    content_template: "{{.Filename}} ({{.Size}} bytes)"
+  - path: /tmp/clips
+    webhook_url: http://example.com/b
+    watch_interval: 30
+`)
+
+	configs, err := watcherConfigs(Config{port: 9090}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 2 {
+		t.Fatalf("expected 2 watchers, got %d", len(configs))
+	}
+
+	shots := configs[0]
+	if shots.path != "/tmp/screenshots" || shots.webhookURL != "http://example.com/a" {
+		t.Errorf("watcher[0] = %+v", shots)
+	}
+	if shots.contentTemplate == nil {
+		t.Error("watcher[0] should have a parsed content template")
+	}
+	if shots.port != 9090 {
+		t.Errorf("watcher[0].port = %d, want inherited base port 9090", shots.port)
+	}
+
+	clips := configs[1]
+	if clips.watch != 30 {
+		t.Errorf("watcher[1].watch = %d, want 30 (from watch_interval)", clips.watch)
+	}
+}
+
+func TestWatcherConfigsRejectsUnknownProcessorPipeline(t *testing.T) {
+	path := writeYAML(t, `
+watchers:
+  - path: /tmp/screenshots
+    webhook_url: http://example.com/a
+    processor_pipeline: missing
+`)
+
+	if _, err := watcherConfigs(Config{}, path); err == nil {
+		t.Error("expected an error for a processor_pipeline that isn't defined")
+	}
+}
+
+func TestWatcherConfigsRejectsInvalidContentTemplate(t *testing.T) {
+	path := writeYAML(t, `
+watchers:
+  - path: /tmp/screenshots
+    webhook_url: http://example.com/a
+    content_template: "{{.Filename"
+`)
+
+	if _, err := watcherConfigs(Config{}, path); err == nil {
+		t.Error("expected an error for an unparseable content_template")
+	}
+}
+
+func TestWatcherConfigsRejectsEmptyFile(t *testing.T) {
+	path := writeYAML(t, `watchers: []`)
+	if _, err := watcherConfigs(Config{}, path); err == nil {
+		t.Error("expected an error for a config file declaring no watchers")
+	}
+}
+
+func TestWatcherConfigsWatermarkOpacityZeroIsExplicit(t *testing.T) {
+	markPath := filepath.Join(t.TempDir(), "mark.png")
+	writeTestPNG(t, markPath, 2, 2)
+
+	path := writeYAML(t, `
+watchers:
+  - path: /tmp/screenshots
+    webhook_url: http://example.com/a
+    processor_pipeline: invisible
+processor_pipelines:
+  invisible:
+    watermark: `+markPath+`
+    watermark_opacity: 0
+`)
+
+	configs, err := watcherConfigs(Config{}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wm, ok := configs[0].pipeline.Processors[0].(WatermarkProcessor)
+	if !ok {
+		t.Fatalf("expected a WatermarkProcessor, got %T", configs[0].pipeline.Processors[0])
+	}
+	if wm.Opacity != 0 {
+		t.Errorf("explicit watermark_opacity: 0 was not honored, got %v", wm.Opacity)
+	}
+}
+
+func TestWatcherConfigsWatermarkOpacityOmittedDefaultsToOpaque(t *testing.T) {
+	markPath := filepath.Join(t.TempDir(), "mark.png")
+	writeTestPNG(t, markPath, 2, 2)
+
+	path := writeYAML(t, `
+watchers:
+  - path: /tmp/screenshots
+    webhook_url: http://example.com/a
+    processor_pipeline: opaque
+processor_pipelines:
+  opaque:
+    watermark: `+markPath+`
+`)
+
+	configs, err := watcherConfigs(Config{}, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wm := configs[0].pipeline.Processors[0].(WatermarkProcessor)
+	if wm.Opacity != 1.0 {
+		t.Errorf("omitted watermark_opacity should default to 1.0, got %v", wm.Opacity)
+	}
+}
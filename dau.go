@@ -7,11 +7,17 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"mime/multipart"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"text/template"
 	"time"
 
 	"github.com/pborman/getopt"
@@ -19,8 +25,12 @@ import (
 
 const currentVersion = "0.5"
 
-var lastCheck = time.Now()
-var newLastCheck = time.Now()
+// maxAttachmentsPerMessage is Discord's limit on the number of file
+// attachments a single webhook message may carry.
+const maxAttachmentsPerMessage = 10
+
+// defaultExtensions is used when a watcher does not set its own extensions.
+var defaultExtensions = []string{".png", ".jpg", ".gif", ".webp"}
 
 // Config for the application
 type Config struct {
@@ -28,25 +38,547 @@ type Config struct {
 	path       string
 	watch      int
 	username   string
+	avatarURL  string
+	content    string
+	embeds     []DiscordEmbed
+	port       int
+	stateFile  string
+	resetState bool
+	pipeline   Pipeline
+
+	extensions      []string
+	excludeGlobs    []string
+	contentTemplate *template.Template
+
+	configFile string
+
+	maxRetries int
+}
+
+// DiscordMessage is the JSON payload sent as the payload_json part of a
+// Discord webhook multipart request, alongside one or more files[n] parts.
+type DiscordMessage struct {
+	Username  string         `json:"username,omitempty"`
+	AvatarURL string         `json:"avatar_url,omitempty"`
+	Content   string         `json:"content,omitempty"`
+	Embeds    []DiscordEmbed `json:"embeds,omitempty"`
+}
+
+// DiscordEmbed is a single rich embed carried on a DiscordMessage.
+type DiscordEmbed struct {
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	URL         string `json:"url,omitempty"`
+	Color       int    `json:"color,omitempty"`
+}
+
+// buildEmbeds turns the --embed-* flags (or a watcher's YAML embed fields)
+// into the embeds to send with every upload. It returns nil unless at least
+// one of title or description is set, so an upload with no embed configured
+// carries no empty embed.
+func buildEmbeds(title, description, url string, color int) []DiscordEmbed {
+	if title == "" && description == "" {
+		return nil
+	}
+	return []DiscordEmbed{{
+		Title:       title,
+		Description: description,
+		URL:         url,
+		Color:       color,
+	}}
+}
+
+// DiscordAPIResponseAttachment describes one attachment in a Discord
+// webhook response.
+type DiscordAPIResponseAttachment struct {
+	URL      string
+	ProxyURL string
+	Size     int
+	Width    int
+	Height   int
+	Filename string
+}
+
+// DiscordAPIResponse is the body Discord returns for a webhook message post.
+type DiscordAPIResponse struct {
+	Attachments []DiscordAPIResponseAttachment
+	ID          int64 `json:",string"`
+}
+
+// Uploader builds and sends batched Discord webhook upload requests.
+type Uploader struct {
+	webhookURL       string
+	client           *http.Client
+	limiter          *rateLimiter
+	maxRetryAttempts int
+}
+
+func newUploader(webhookURL string, maxRetryAttempts int) *Uploader {
+	return &Uploader{
+		webhookURL:       webhookURL,
+		client:           &http.Client{Timeout: time.Second * 30},
+		limiter:          newRateLimiter(),
+		maxRetryAttempts: maxRetryAttempts,
+	}
+}
+
+// uploaderPool hands out one Uploader per webhook URL, so the rate limiter
+// (and the per-bucket throttling it has learned) is shared across every
+// watcher and every batch that posts to the same webhook, rather than
+// being thrown away and rebuilt from scratch on each upload.
+type uploaderPool struct {
+	mu        sync.Mutex
+	uploaders map[string]*Uploader
+}
+
+func newUploaderPool() *uploaderPool {
+	return &uploaderPool{uploaders: map[string]*Uploader{}}
+}
+
+// get returns the Uploader for webhookURL, creating one with maxRetryAttempts
+// if this is the first request for it. maxRetryAttempts is only consulted on
+// creation - watchers sharing a webhook URL share its Uploader, and with it
+// whichever maxRetryAttempts was configured first.
+func (p *uploaderPool) get(webhookURL string, maxRetryAttempts int) *Uploader {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if u, ok := p.uploaders[webhookURL]; ok {
+		return u
+	}
+	u := newUploader(webhookURL, maxRetryAttempts)
+	p.uploaders[webhookURL] = u
+	return u
+}
+
+// defaultMaxRetryAttempts is used when a watcher does not configure its own
+// --max-retries.
+const defaultMaxRetryAttempts = 5
+
+// rateLimiter serializes requests per Discord rate-limit bucket, sleeping
+// ahead of a request when the bucket is already known to be exhausted.
+type rateLimiter struct {
+	mu      sync.Mutex
+	buckets map[string]*bucketState
+}
+
+// bucketState tracks the last known remaining requests and reset time for
+// a single Discord rate-limit bucket.
+type bucketState struct {
+	remaining int
+	resetAt   time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{buckets: map[string]*bucketState{}}
+}
+
+// wait blocks until key's bucket, if known to be exhausted, has reset.
+func (r *rateLimiter) wait(key string) {
+	r.mu.Lock()
+	b, ok := r.buckets[key]
+	r.mu.Unlock()
+	if !ok || b.remaining > 0 {
+		return
+	}
+	if d := time.Until(b.resetAt); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// update records the rate-limit state reported by resp for key, and also
+// under the bucket id in X-RateLimit-Bucket when Discord supplies one, so
+// later requests to the same bucket are recognised even via a different key.
+func (r *rateLimiter) update(key string, resp *http.Response) {
+	remaining, hasRemaining := headerInt(resp, "X-RateLimit-Remaining")
+	resetAfter, hasResetAfter := headerFloat(resp, "X-RateLimit-Reset-After")
+	if !hasRemaining && !hasResetAfter {
+		return
+	}
+
+	state := &bucketState{
+		remaining: remaining,
+		resetAt:   time.Now().Add(time.Duration(resetAfter * float64(time.Second))),
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.buckets[key] = state
+	if bucket := resp.Header.Get("X-RateLimit-Bucket"); bucket != "" {
+		r.buckets[bucket] = state
+	}
+}
+
+func headerInt(resp *http.Response, name string) (int, bool) {
+	v := resp.Header.Get(name)
+	if v == "" {
+		return 0, false
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
+
+func headerFloat(resp *http.Response, name string) (float64, bool) {
+	v := resp.Header.Get(name)
+	if v == "" {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, false
+	}
+	return f, true
+}
+
+// doWithRateLimiting sends req honoring Discord's per-bucket rate-limit
+// headers, retrying on 429 (sleeping for the server-reported retry_after
+// plus jitter) and on 5xx (exponential backoff), up to u.maxRetryAttempts.
+// Every webhook call should route through this so a burst of uploads
+// doesn't lose screenshots to a dropped rate-limited request.
+func (u *Uploader) doWithRateLimiting(req *http.Request) (*http.Response, error) {
+	bucketKey := req.URL.Path
+
+	var lastErr error
+	for attempt := 1; attempt <= u.maxRetryAttempts; attempt++ {
+		u.limiter.wait(bucketKey)
+
+		attemptReq, err := cloneRequest(req)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := u.client.Do(attemptReq)
+		if err != nil {
+			lastErr = err
+			time.Sleep(backoffDelay(attempt))
+			continue
+		}
+
+		u.limiter.update(bucketKey, resp)
+
+		if resp.StatusCode == http.StatusTooManyRequests {
+			retryAfter := parseRetryAfter(resp)
+			resp.Body.Close()
+			lastErr = fmt.Errorf("rate limited (attempt %d)", attempt)
+			time.Sleep(retryAfter + jitter())
+			continue
+		}
+
+		if resp.StatusCode >= 500 {
+			resp.Body.Close()
+			lastErr = fmt.Errorf("server error %d (attempt %d)", resp.StatusCode, attempt)
+			time.Sleep(backoffDelay(attempt))
+			continue
+		}
+
+		return resp, nil
+	}
+
+	return nil, fmt.Errorf("giving up after %d attempts: %w", u.maxRetryAttempts, lastErr)
+}
+
+func cloneRequest(req *http.Request) (*http.Request, error) {
+	clone := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		clone.Body = io.NopCloser(body)
+	}
+	return clone, nil
+}
+
+// parseRetryAfter reads the 429 JSON body's retry_after (seconds, float),
+// falling back to the Retry-After header and then a one second default.
+func parseRetryAfter(resp *http.Response) time.Duration {
+	type retryAfterBody struct {
+		RetryAfter float64 `json:"retry_after"`
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err == nil {
+		var parsed retryAfterBody
+		if err := json.Unmarshal(body, &parsed); err == nil && parsed.RetryAfter > 0 {
+			return time.Duration(parsed.RetryAfter * float64(time.Second))
+		}
+	}
+
+	if header := resp.Header.Get("Retry-After"); header != "" {
+		if secs, err := strconv.ParseFloat(header, 64); err == nil {
+			return time.Duration(secs * float64(time.Second))
+		}
+	}
+
+	return time.Second
+}
+
+func backoffDelay(attempt int) time.Duration {
+	return time.Duration(attempt*attempt) * 500 * time.Millisecond
+}
+
+func jitter() time.Duration {
+	return time.Duration(rand.Intn(250)) * time.Millisecond
+}
+
+// Upload posts a single Discord webhook message carrying the given files
+// (at most maxAttachmentsPerMessage of them) described by msg. Each file is
+// run through pipeline before being attached.
+func (u *Uploader) Upload(paths []string, msg DiscordMessage, pipeline Pipeline) (*DiscordAPIResponse, error) {
+	if len(paths) > maxAttachmentsPerMessage {
+		return nil, fmt.Errorf("cannot upload %d files in a single message, max is %d", len(paths), maxAttachmentsPerMessage)
+	}
+
+	request, err := newMessageUploadRequest(u.webhookURL, msg, paths, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := u.doWithRateLimiting(request)
+	if err != nil {
+		return nil, fmt.Errorf("error performing request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("bad response from server: %d", resp.StatusCode)
+	}
+
+	resBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read response body: %w", err)
+	}
+
+	var res DiscordAPIResponse
+	if err := json.Unmarshal(resBody, &res); err != nil {
+		return nil, fmt.Errorf("could not parse JSON: %w (body: %s)", err, resBody)
+	}
+
+	return &res, nil
+}
+
+// newMessageUploadRequest builds a multipart Discord webhook request
+// carrying a payload_json field followed by one files[n] part per path.
+func newMessageUploadRequest(uri string, msg DiscordMessage, paths []string, pipeline Pipeline) (*http.Request, error) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	if err := writer.WriteField("payload_json", string(payload)); err != nil {
+		return nil, err
+	}
+
+	for i, path := range paths {
+		if err := addFilePart(writer, i, path, pipeline); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", uri, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req, nil
+}
+
+// addFilePart attaches path as files[index]. Files that need no processing
+// (no pipeline configured, and not a format Discord can't render inline)
+// are streamed through unmodified; everything else is decoded, run through
+// pipeline and re-encoded straight into the part writer.
+func addFilePart(writer *multipart.Writer, index int, path string, pipeline Pipeline) error {
+	extension := strings.ToLower(filepath.Ext(path))
+	needsDecode := extension == ".webp" || extension == ".heic" || extension == ".heif"
+	if pipeline.Empty() && !needsDecode {
+		return addRawFilePart(writer, index, path)
+	}
+
+	img, sourceFormat, err := decodeImage(path)
+	if err != nil {
+		return err
+	}
+
+	img, err = pipeline.Process(img)
+	if err != nil {
+		return err
+	}
+
+	outputFormat := pipeline.OutputFormat
+	if outputFormat == "" {
+		outputFormat = sourceFormat
+	}
+
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)) + "." + outputExtension(outputFormat)
+	part, err := writer.CreateFormFile(fmt.Sprintf("files[%d]", index), name)
+	if err != nil {
+		return err
+	}
+
+	return encodeImage(part, img, outputFormat)
+}
+
+func addRawFilePart(writer *multipart.Writer, index int, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	part, err := writer.CreateFormFile(fmt.Sprintf("files[%d]", index), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, file)
+	return err
+}
+
+func outputExtension(format string) string {
+	switch format {
+	case "jpeg":
+		return "jpg"
+	case "webp":
+		return "png"
+	default:
+		return format
+	}
+}
+
+// daemon bundles the dependencies shared by every watcher goroutine: the
+// control server (also the holder of each watcher's live config), the
+// on-disk state store, and the uploader pool that serializes requests per
+// Discord rate-limit bucket.
+type daemon struct {
+	server *Server
+	state  *State
+	pool   *uploaderPool
 }
 
 func main() {
 
-	config := parseOptions()
+	cliConfig := parseOptions()
 
-	checkPath(config.path)
-	checkUpdates()
+	if cliConfig.resetState {
+		if err := resetState(cliConfig.stateFile); err != nil {
+			log.Fatal("could not reset state: ", err)
+		}
+		log.Print("State reset")
+	}
+
+	configs, err := watcherConfigs(cliConfig, cliConfig.configFile)
+	if err != nil {
+		log.Fatal("could not load config: ", err)
+	}
+	for _, cfg := range configs {
+		checkPath(cfg.path)
+	}
+
+	state, err := loadState(cliConfig.stateFile)
+	if err != nil {
+		log.Fatal("could not load state: ", err)
+	}
+
+	pool := newUploaderPool()
+	server := newServer(cliConfig, state, configs, pool)
+	d := &daemon{server: server, state: state, pool: pool}
+
+	go func() {
+		if err := server.ListenAndServe(); err != nil {
+			log.Fatal("control server failed: ", err)
+		}
+	}()
+
+	checkUpdates(server)
+
+	for _, cfg := range configs {
+		go runWatcher(cfg.path, d)
+	}
+
+	if cliConfig.configFile != "" {
+		go watchForReload(cliConfig, server)
+	}
 
-	log.Print("Waiting for images to appear in ", config.path)
-	// wander the path, forever
+	select {}
+}
+
+// watchForReload re-reads configFile and pushes the resulting watcher
+// configs into the server on SIGHUP, so `kill -HUP` (or a future
+// `/config` PUT) can change routing, filters or templates without
+// restarting the process or dropping uploads already in flight.
+func watchForReload(cliConfig Config, server *Server) {
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+
+	for range sighup {
+		configs, err := watcherConfigs(cliConfig, cliConfig.configFile)
+		if err != nil {
+			log.Print("could not reload config: ", err)
+			continue
+		}
+		// only watchers present at startup can be reloaded; new paths
+		// require a restart since nothing is watching them yet.
+		for _, cfg := range configs {
+			server.SetWatcherConfig(cfg.path, cfg)
+		}
+		log.Print("Config reloaded")
+	}
+}
+
+// runWatcher scans cfg.path on a loop, uploading anything eligible it
+// finds, until the process exits. Its own copy of the config is re-read
+// from the server at the top of every cycle, so a live config update (via
+// SIGHUP or /config) takes effect on the next scan.
+func runWatcher(path string, d *daemon) {
+	cfg := d.server.WatcherConfig(path)
+
+	lastCheck := time.Now()
+	newLastCheck := time.Now()
+	if t, ok := d.state.LastCheckFor(path); ok {
+		lastCheck = t
+		newLastCheck = t
+	} else if d.state != nil {
+		// first time this path has been watched: seed every file already
+		// there as uploaded, so they aren't mistaken for new arrivals.
+		if err := seedExistingFiles(cfg, d.state); err != nil {
+			log.Print("could not seed existing files in ", path, ": ", err)
+		}
+	}
+
+	log.Print("Waiting for images to appear in ", path)
 	for {
-		err := filepath.Walk(config.path,
-			func(path string, f os.FileInfo, err error) error { return checkFile(path, f, err, config) })
+		cfg = d.server.WatcherConfig(path)
+
+		var eligible []string
+		walkLastCheck := lastCheck
+		nextLastCheck := newLastCheck
+		err := filepath.Walk(cfg.path, func(p string, f os.FileInfo, err error) error {
+			return checkFile(p, f, err, cfg, &eligible, d.state, walkLastCheck, &nextLastCheck)
+		})
 		if err != nil {
-			log.Fatal("could not watch path", err)
+			log.Print("could not watch path ", cfg.path, ": ", err)
 		}
+		newLastCheck = nextLastCheck
+
+		d.server.recordScan(cfg.path, len(eligible))
+		if len(eligible) > 0 {
+			processFiles(cfg, eligible, d)
+		}
+
 		lastCheck = newLastCheck
-		time.Sleep(time.Duration(config.watch) * time.Second)
+		if err := d.state.SetLastCheck(cfg.path, lastCheck); err != nil {
+			log.Print("could not persist last check: ", err)
+		}
+
+		time.Sleep(time.Duration(cfg.watch) * time.Second)
 	}
 }
 
@@ -61,7 +593,7 @@ func checkPath(path string) {
 	}
 }
 
-func checkUpdates() {
+func checkUpdates(server *Server) {
 
 	type GithubRelease struct {
 		HTMLURL string
@@ -73,21 +605,26 @@ func checkUpdates() {
 	client := &http.Client{Timeout: time.Second * 5}
 	resp, err := client.Get("https://api.github.com/repos/tardisx/discord-auto-upload/releases/latest")
 	if err != nil {
-		log.Fatal("could not check for updates:", err)
+		log.Print("could not check for updates: ", err)
+		return
 	}
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.Fatal("could not check read update response")
+		log.Print("could not read update response: ", err)
+		return
 	}
 
 	var latest GithubRelease
 	err = json.Unmarshal(body, &latest)
 
 	if err != nil {
-		log.Fatal("could not parse JSON: ", err)
+		log.Print("could not parse JSON: ", err)
+		return
 	}
 
+	server.setLatestVersion(latest.TagName)
+
 	if currentVersion < latest.TagName {
 		fmt.Printf("You are currently on version %s, but version %s is available\n", currentVersion, latest.TagName)
 		fmt.Println("----------- Release Info -----------")
@@ -105,6 +642,23 @@ func parseOptions() Config {
 	pathFlag := getopt.StringLong("directory", 'd', "", "directory to scan, optional, defaults to current directory")
 	watchFlag := getopt.Int16Long("watch", 's', 10, "time between scans")
 	usernameFlag := getopt.StringLong("username", 'u', "", "username for the bot upload")
+	avatarFlag := getopt.StringLong("avatar", 'a', "", "avatar URL for the bot upload")
+	contentFlag := getopt.StringLong("content", 'c', "", "message content to accompany the uploaded files")
+	portFlag := getopt.Int16Long("port", 'p', 9090, "port for the local control server, bound to 127.0.0.1")
+	stateFileFlag := getopt.StringLong("state-file", 0, defaultStatePath(), "path to the state file tracking watcher progress and upload history")
+	resetStateFlag := getopt.BoolLong("reset-state", 0, "wipe the state file before starting")
+	maxRetriesFlag := getopt.IntLong("max-retries", 0, defaultMaxRetryAttempts, "max attempts for a webhook request that hits a 429 or a 5xx response")
+	maxWidthFlag := getopt.IntLong("max-width", 0, 0, "downscale images wider than this, preserving aspect ratio (0 disables)")
+	maxHeightFlag := getopt.IntLong("max-height", 0, 0, "downscale images taller than this, preserving aspect ratio (0 disables)")
+	watermarkFlag := getopt.StringLong("watermark", 0, "", "path to a PNG/image watermark to overlay on every upload")
+	watermarkOpacityFlag := getopt.StringLong("watermark-opacity", 0, "1.0", "watermark opacity, 0 (invisible) to 1 (opaque)")
+	stripEXIFFlag := getopt.BoolLong("strip-exif", 0, "strip EXIF metadata from uploaded images")
+	convertToFlag := getopt.StringLong("convert-to", 0, "", "re-encode uploads to this format (png or jpeg)")
+	embedTitleFlag := getopt.StringLong("embed-title", 0, "", "title for an embed to accompany the uploaded files")
+	embedDescriptionFlag := getopt.StringLong("embed-description", 0, "", "description for an embed to accompany the uploaded files")
+	embedURLFlag := getopt.StringLong("embed-url", 0, "", "URL for an embed to accompany the uploaded files")
+	embedColorFlag := getopt.IntLong("embed-color", 0, 0, "decimal color for an embed to accompany the uploaded files")
+	configFlag := getopt.StringLong("config", 0, "", "YAML file declaring multiple independent watchers, overriding the other flags")
 	helpFlag := getopt.BoolLong("help", 'h', "help")
 	versionFlag := getopt.BoolLong("version", 'v', "show version")
 	getopt.SetParameters("")
@@ -122,144 +676,220 @@ func parseOptions() Config {
 		os.Exit(0)
 	}
 
-	if !getopt.IsSet("directory") {
-		*pathFlag = "./"
-		log.Println("Defaulting to current directory")
-	}
+	newConfig.configFile = *configFlag
+
+	if newConfig.configFile == "" {
+		if !getopt.IsSet("directory") {
+			*pathFlag = "./"
+			log.Println("Defaulting to current directory")
+		}
 
-	if !getopt.IsSet("webhook") {
-		log.Fatal("ERROR: You must specify a --webhook URL")
+		if !getopt.IsSet("webhook") {
+			log.Fatal("ERROR: You must specify a --webhook URL, or a --config file")
+		}
 	}
 
 	newConfig.path = *pathFlag
 	newConfig.webhookURL = *webhookFlag
 	newConfig.watch = int(*watchFlag)
 	newConfig.username = *usernameFlag
+	newConfig.avatarURL = *avatarFlag
+	newConfig.content = *contentFlag
+	newConfig.port = int(*portFlag)
+	newConfig.stateFile = *stateFileFlag
+	newConfig.resetState = *resetStateFlag
+	newConfig.maxRetries = *maxRetriesFlag
+	newConfig.pipeline = buildPipeline(*maxWidthFlag, *maxHeightFlag, *watermarkFlag, *watermarkOpacityFlag, *stripEXIFFlag, *convertToFlag)
+	newConfig.embeds = buildEmbeds(*embedTitleFlag, *embedDescriptionFlag, *embedURLFlag, *embedColorFlag)
 
 	return newConfig
 }
 
-func checkFile(path string, f os.FileInfo, err error, config Config) error {
+// checkFile is filepath.Walk's visitor for a single scan cycle. lastCheck is
+// the watcher's last scan time; newLastCheck is updated in place with the
+// newest mtime seen, becoming the next cycle's lastCheck.
+func checkFile(path string, f os.FileInfo, err error, config Config, eligible *[]string, state *State, lastCheck time.Time, newLastCheck *time.Time) error {
 
-	if f.ModTime().After(lastCheck) && f.Mode().IsRegular() {
+	if !f.Mode().IsRegular() {
+		return nil
+	}
 
-		if fileEligible(config, path) {
-			// process file
-			processFile(config, path)
-		}
+	isNew := f.ModTime().After(lastCheck)
+	if isNew && newLastCheck.Before(f.ModTime()) {
+		*newLastCheck = f.ModTime()
+	}
 
-		if newLastCheck.Before(f.ModTime()) {
-			newLastCheck = f.ModTime()
-		}
+	if !fileEligible(config, path) {
+		return nil
+	}
+
+	// A file is eligible if it's new since lastCheck, or if it was never
+	// actually recorded as uploaded - the latter also catches a file whose
+	// upload previously failed: its mtime advances lastCheck regardless
+	// (see runWatcher), but it's never added to state.Uploads, so it keeps
+	// being retried instead of being silently dropped forever.
+	alreadyUploaded := state != nil && state.Seen(absPath(path), f.ModTime(), f.Size())
+	if isNew || !alreadyUploaded {
+		*eligible = append(*eligible, path)
 	}
 
 	return nil
 }
 
-func fileEligible(config Config, file string) bool {
-	extension := strings.ToLower(filepath.Ext(file))
-	if extension == ".png" || extension == ".jpg" || extension == ".gif" {
-		return true
-	}
-	return false
+// seedExistingFiles marks every file already in cfg.path that fileEligible
+// would pick up as already uploaded, without actually uploading it. It is
+// called once, the first time a path is watched (no prior lastCheck
+// recorded for it), so that pre-existing files aren't mistaken for new
+// arrivals and mass-uploaded - see seedExistingFiles' caller in runWatcher.
+func seedExistingFiles(cfg Config, state *State) error {
+	return filepath.Walk(cfg.path, func(p string, f os.FileInfo, err error) error {
+		if err != nil || !f.Mode().IsRegular() || !fileEligible(cfg, p) {
+			return nil
+		}
+		return state.Seed(absPath(p), f.ModTime(), f.Size())
+	})
 }
 
-func processFile(config Config, file string) {
-	log.Print("Uploading ", file)
-
-	extraParams := map[string]string{}
-
-	if config.username != "" {
-		extraParams["username"] = config.username
+// fileEligible reports whether file should be uploaded, based on the
+// watcher's configured extensions (falling back to defaultExtensions) and
+// excludeGlobs (matched against the file's base name).
+func fileEligible(config Config, file string) bool {
+	extensions := config.extensions
+	if len(extensions) == 0 {
+		extensions = defaultExtensions
 	}
 
-	type DiscordAPIResponseAttachment struct {
-		URL      string
-		ProxyURL string
-		Size     int
-		Width    int
-		Height   int
-		Filename string
+	extension := strings.ToLower(filepath.Ext(file))
+	eligible := false
+	for _, e := range extensions {
+		if strings.ToLower(e) == extension {
+			eligible = true
+			break
+		}
 	}
-
-	type DiscordAPIResponse struct {
-		Attachments []DiscordAPIResponseAttachment
-		ID          int64 `json:",string"`
+	if !eligible {
+		return false
 	}
 
-	request, err := newfileUploadRequest(config.webhookURL, extraParams, "file", file)
-	if err != nil {
-		log.Fatal(err)
+	base := filepath.Base(file)
+	for _, glob := range config.excludeGlobs {
+		if matched, err := filepath.Match(glob, base); err == nil && matched {
+			return false
+		}
 	}
-	start := time.Now()
-	client := &http.Client{Timeout: time.Second * 30}
-	resp, err := client.Do(request)
-	if err != nil {
-
-		log.Fatal("Error performing request:", err)
-
-	} else {
 
-		if resp.StatusCode != 200 {
-			log.Print("Bad response from server:", resp.StatusCode)
-			return
-		}
+	return true
+}
 
-		resBody, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			log.Fatal("could not deal with body", err)
+// processFiles uploads the files discovered in a single scan cycle,
+// splitting them into Discord messages of at most maxAttachmentsPerMessage
+// files each.
+func processFiles(config Config, files []string, d *daemon) {
+	for i := 0; i < len(files); i += maxAttachmentsPerMessage {
+		end := i + maxAttachmentsPerMessage
+		if end > len(files) {
+			end = len(files)
 		}
-		resp.Body.Close()
+		uploadBatch(config, files[i:end], d)
+	}
+}
 
-		var res DiscordAPIResponse
-		err = json.Unmarshal(resBody, &res)
+// templateData is exposed to a watcher's content_template.
+type templateData struct {
+	Filename  string
+	Size      int64
+	Timestamp time.Time
+}
 
-		if err != nil {
-			log.Print("could not parse JSON: ", err)
-			fmt.Println("Response was:", string(resBody[:]))
-			return
-		}
-		if len(res.Attachments) < 1 {
-			log.Print("bad response - no attachments?")
-			return
-		}
-		var a = res.Attachments[0]
-		elapsed := time.Since(start)
-		rate := float64(a.Size) / elapsed.Seconds() / 1024.0
+// renderContent produces the message content for files[0], using
+// config.contentTemplate if set and falling back to config.content on a
+// render error or when no template is configured.
+func renderContent(config Config, path string) string {
+	if config.contentTemplate == nil {
+		return config.content
+	}
 
-		log.Printf("Uploaded to %s %dx%d", a.URL, a.Width, a.Height)
-		log.Printf("id: %d, %d bytes transferred in %.2f seconds (%.2f KiB/s)", res.ID, a.Size, elapsed.Seconds(), rate)
+	data := templateData{Filename: filepath.Base(path), Timestamp: time.Now()}
+	if info, err := os.Stat(path); err == nil {
+		data.Size = info.Size()
 	}
 
+	var buf bytes.Buffer
+	if err := config.contentTemplate.Execute(&buf, data); err != nil {
+		log.Print("could not render content template: ", err)
+		return config.content
+	}
+	return buf.String()
 }
 
-func newfileUploadRequest(uri string, params map[string]string, paramName, path string) (*http.Request, error) {
-	file, err := os.Open(path)
-	if err != nil {
-		return nil, err
+func uploadBatch(config Config, files []string, d *daemon) {
+	log.Print("Uploading ", strings.Join(files, ", "))
+
+	msg := DiscordMessage{
+		Username:  config.username,
+		AvatarURL: config.avatarURL,
+		Content:   renderContent(config, files[0]),
+		Embeds:    config.embeds,
 	}
-	defer file.Close()
 
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-	part, err := writer.CreateFormFile(paramName, filepath.Base(path))
+	uploader := d.pool.get(config.webhookURL, config.maxRetries)
+	server := d.server
+	state := d.state
+	start := time.Now()
+	res, err := uploader.Upload(files, msg, config.pipeline)
 	if err != nil {
-		return nil, err
+		log.Print("Error uploading: ", err)
+		return
 	}
-	_, err = io.Copy(part, file)
-	if err != nil {
-		log.Fatal("Could not copy: ", err)
+	if len(res.Attachments) < 1 {
+		log.Print("bad response - no attachments?")
+		return
 	}
 
-	for key, val := range params {
-		_ = writer.WriteField(key, val)
-	}
-	err = writer.Close()
-	if err != nil {
-		return nil, err
+	elapsed := time.Since(start)
+
+	var totalSize int
+	for i, a := range res.Attachments {
+		totalSize += a.Size
+		log.Printf("Uploaded to %s %dx%d", a.URL, a.Width, a.Height)
+
+		filename := a.Filename
+		var sourcePath string
+		if i < len(files) {
+			sourcePath = files[i]
+			filename = filepath.Base(sourcePath)
+		}
+
+		if server != nil {
+			server.recordUpload(UploadRecord{
+				Filename:  filename,
+				URL:       a.URL,
+				Size:      a.Size,
+				Duration:  elapsed.Seconds(),
+				Timestamp: time.Now(),
+			})
+		}
+
+		if state != nil && sourcePath != "" {
+			if info, statErr := os.Stat(sourcePath); statErr == nil {
+				abs := absPath(sourcePath)
+				rec := UploadedFileRecord{
+					Path:       abs,
+					ModTime:    info.ModTime(),
+					Size:       info.Size(),
+					URL:        a.URL,
+					Width:      a.Width,
+					Height:     a.Height,
+					MessageID:  res.ID,
+					UploadedAt: time.Now(),
+				}
+				if err := state.RecordUpload(abs, info.ModTime(), info.Size(), rec); err != nil {
+					log.Print("could not persist upload state: ", err)
+				}
+			}
+		}
 	}
 
-	req, err := http.NewRequest("POST", uri, body)
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-	return req, err
+	rate := float64(totalSize) / elapsed.Seconds() / 1024.0
+	log.Printf("id: %d, %d bytes transferred in %.2f seconds (%.2f KiB/s)", res.ID, totalSize, elapsed.Seconds(), rate)
 }
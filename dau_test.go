@@ -0,0 +1,180 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileEligible(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		file   string
+		want   bool
+	}{
+		{"default extensions accept png", Config{}, "/tmp/shot.png", true},
+		{"default extensions reject txt", Config{}, "/tmp/notes.txt", false},
+		{"configured extensions override defaults", Config{extensions: []string{".mp4"}}, "/tmp/clip.mp4", true},
+		{"configured extensions exclude png", Config{extensions: []string{".mp4"}}, "/tmp/shot.png", false},
+		{"excludeGlobs filters a matching file", Config{excludeGlobs: []string{"tmp_*.png"}}, "/tmp/tmp_shot.png", false},
+		{"excludeGlobs leaves non-matching files alone", Config{excludeGlobs: []string{"tmp_*.png"}}, "/tmp/shot.png", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := fileEligible(tt.config, tt.file); got != tt.want {
+				t.Errorf("fileEligible(%+v, %q) = %v, want %v", tt.config, tt.file, got, tt.want)
+			}
+		})
+	}
+}
+
+// touch creates an empty file at path with the given mtime.
+func touch(t *testing.T, path string, modTime time.Time) os.FileInfo {
+	t.Helper()
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+func TestCheckFileSkipsPreExistingFilesOnFirstRun(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "old.png")
+	oldModTime := time.Now().Add(-48 * time.Hour)
+	info := touch(t, path, oldModTime)
+
+	cfg := Config{path: dir}
+	state := &State{path: filepath.Join(dir, "state.json"), LastCheck: map[string]time.Time{}, Uploads: map[string]UploadedFileRecord{}}
+
+	// seedExistingFiles is what runWatcher calls, exactly once, the first
+	// time a path is watched - before that has run, checkFile alone can't
+	// tell a pre-existing file from a genuinely new one.
+	if err := seedExistingFiles(cfg, state); err != nil {
+		t.Fatal(err)
+	}
+
+	lastCheck := time.Now()
+	newLastCheck := lastCheck
+	var eligible []string
+
+	if err := checkFile(path, info, nil, cfg, &eligible, state, lastCheck, &newLastCheck); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(eligible) != 0 {
+		t.Errorf("pre-existing file with old mtime was marked eligible on first run: %v", eligible)
+	}
+}
+
+func TestSeedExistingFilesOnlySeedsEligibleFiles(t *testing.T) {
+	dir := t.TempDir()
+	png := touch(t, filepath.Join(dir, "shot.png"), time.Now().Add(-time.Hour))
+	txt := touch(t, filepath.Join(dir, "notes.txt"), time.Now().Add(-time.Hour))
+
+	cfg := Config{path: dir}
+	state := &State{path: filepath.Join(dir, "state.json"), LastCheck: map[string]time.Time{}, Uploads: map[string]UploadedFileRecord{}}
+	if err := seedExistingFiles(cfg, state); err != nil {
+		t.Fatal(err)
+	}
+
+	if !state.Seen(absPath(filepath.Join(dir, "shot.png")), png.ModTime(), png.Size()) {
+		t.Error("eligible pre-existing file was not seeded")
+	}
+	if state.Seen(absPath(filepath.Join(dir, "notes.txt")), txt.ModTime(), txt.Size()) {
+		t.Error("ineligible file was seeded")
+	}
+}
+
+func TestCheckFileRetriesAfterFailedUpload(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "flaky.png")
+	modTime := time.Now()
+	info := touch(t, path, modTime)
+
+	state := &State{path: filepath.Join(dir, "state.json"), LastCheck: map[string]time.Time{}, Uploads: map[string]UploadedFileRecord{}}
+
+	// scan 1: the file is new and gets picked up. Its upload then "fails",
+	// so it's deliberately never recorded via state.RecordUpload.
+	lastCheck := modTime.Add(-time.Hour)
+	newLastCheck := lastCheck
+	var eligible []string
+	if err := checkFile(path, info, nil, Config{}, &eligible, state, lastCheck, &newLastCheck); err != nil {
+		t.Fatal(err)
+	}
+	if len(eligible) != 1 {
+		t.Fatalf("expected the file eligible on scan 1, got %v", eligible)
+	}
+
+	// scan 2: runWatcher advances lastCheck past the file's mtime
+	// regardless of whether the upload succeeded. Since it was never
+	// recorded as uploaded, it must still be retried rather than silently
+	// dropped forever.
+	lastCheck = newLastCheck
+	newLastCheck = lastCheck
+	eligible = nil
+	if err := checkFile(path, info, nil, Config{}, &eligible, state, lastCheck, &newLastCheck); err != nil {
+		t.Fatal(err)
+	}
+	if len(eligible) != 1 {
+		t.Errorf("file with a failed upload was not retried after lastCheck advanced: %v", eligible)
+	}
+}
+
+func TestCheckFileUploadsNewFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "new.png")
+	lastCheck := time.Now().Add(-time.Hour)
+	newModTime := time.Now()
+	info := touch(t, path, newModTime)
+
+	newLastCheck := lastCheck
+	var eligible []string
+
+	if err := checkFile(path, info, nil, Config{}, &eligible, nil, lastCheck, &newLastCheck); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(eligible) != 1 || eligible[0] != path {
+		t.Errorf("expected %q to be eligible, got %v", path, eligible)
+	}
+	if !newLastCheck.Equal(newModTime) {
+		t.Errorf("newLastCheck = %v, want %v", newLastCheck, newModTime)
+	}
+}
+
+func TestCheckFileSuppressesAlreadyUploadedUnchangedFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "reuploaded.png")
+	modTime := time.Now()
+	info := touch(t, path, modTime)
+
+	state := &State{path: filepath.Join(dir, "state.json"), LastCheck: map[string]time.Time{}, Uploads: map[string]UploadedFileRecord{}}
+	abs := absPath(path)
+	if err := state.RecordUpload(abs, info.ModTime(), info.Size(), UploadedFileRecord{Path: abs}); err != nil {
+		t.Fatal(err)
+	}
+
+	// lastCheck has caught up to the file's mtime (as it does on the scan
+	// right after a successful upload), so it's no longer isNew; only
+	// alreadyUploaded governs whether it's picked up again.
+	lastCheck := modTime
+	newLastCheck := lastCheck
+	var eligible []string
+	if err := checkFile(path, info, nil, Config{}, &eligible, state, lastCheck, &newLastCheck); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(eligible) != 0 {
+		t.Errorf("unchanged file already recorded as uploaded was re-uploaded: %v", eligible)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/webp"
+)
+
+// ImageProcessor transforms a decoded image before it is uploaded, e.g. to
+// resize, watermark or strip metadata. Implementations must not mutate img
+// in place if the caller might still be holding a reference to it.
+type ImageProcessor interface {
+	Process(img image.Image) (image.Image, error)
+}
+
+// Pipeline runs a sequence of ImageProcessors over a decoded image, each
+// processor's output feeding the next, and controls what format the result
+// is re-encoded in.
+type Pipeline struct {
+	Processors   []ImageProcessor
+	OutputFormat string // "", "png" or "jpeg"; "" keeps the source format
+}
+
+// Process runs img through every processor in order.
+func (p Pipeline) Process(img image.Image) (image.Image, error) {
+	var err error
+	for _, proc := range p.Processors {
+		img, err = proc.Process(img)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return img, nil
+}
+
+// Empty reports whether the pipeline has nothing to do: no processors and
+// no forced output format, in which case the source file can be streamed
+// unmodified rather than decoded and re-encoded.
+func (p Pipeline) Empty() bool {
+	return len(p.Processors) == 0 && p.OutputFormat == ""
+}
+
+// ResizeProcessor downscales an image to fit within MaxWidth x MaxHeight,
+// preserving aspect ratio. Images already within bounds are left untouched,
+// since Discord re-encodes anything over its size limit anyway and there is
+// no point shrinking further.
+type ResizeProcessor struct {
+	MaxWidth  int
+	MaxHeight int
+}
+
+func (r ResizeProcessor) Process(img image.Image) (image.Image, error) {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= r.MaxWidth && h <= r.MaxHeight {
+		return img, nil
+	}
+
+	scale := math.Min(float64(r.MaxWidth)/float64(w), float64(r.MaxHeight)/float64(h))
+	newW := maxInt(1, int(float64(w)*scale))
+	newH := maxInt(1, int(float64(h)*scale))
+
+	dst := image.NewRGBA(image.Rect(0, 0, newW, newH))
+	for y := 0; y < newH; y++ {
+		srcY := bounds.Min.Y + y*h/newH
+		for x := 0; x < newW; x++ {
+			srcX := bounds.Min.X + x*w/newW
+			dst.Set(x, y, img.At(srcX, srcY))
+		}
+	}
+	return dst, nil
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// WatermarkCorner identifies which corner of the image a watermark is
+// anchored to.
+type WatermarkCorner int
+
+// Watermark corner options for WatermarkProcessor.
+const (
+	WatermarkTopLeft WatermarkCorner = iota
+	WatermarkTopRight
+	WatermarkBottomLeft
+	WatermarkBottomRight
+)
+
+// WatermarkProcessor overlays Mark at Corner with the given Opacity
+// (0 = invisible, 1 = fully opaque).
+type WatermarkProcessor struct {
+	Mark    image.Image
+	Corner  WatermarkCorner
+	Opacity float64
+}
+
+func (w WatermarkProcessor) Process(img image.Image) (image.Image, error) {
+	bounds := img.Bounds()
+	dst := image.NewRGBA(bounds)
+	draw.Draw(dst, bounds, img, bounds.Min, draw.Src)
+
+	mb := w.Mark.Bounds()
+	var ox, oy int
+	switch w.Corner {
+	case WatermarkTopLeft:
+		ox, oy = bounds.Min.X, bounds.Min.Y
+	case WatermarkTopRight:
+		ox, oy = bounds.Max.X-mb.Dx(), bounds.Min.Y
+	case WatermarkBottomLeft:
+		ox, oy = bounds.Min.X, bounds.Max.Y-mb.Dy()
+	case WatermarkBottomRight:
+		ox, oy = bounds.Max.X-mb.Dx(), bounds.Max.Y-mb.Dy()
+	}
+
+	dstRect := image.Rect(ox, oy, ox+mb.Dx(), oy+mb.Dy()).Intersect(bounds)
+	mask := image.NewUniform(color.Alpha{A: uint8(w.Opacity * 255)})
+	draw.DrawMask(dst, dstRect, w.Mark, mb.Min, mask, image.Point{}, draw.Over)
+
+	return dst, nil
+}
+
+// StripEXIFProcessor is a no-op marker for the pipeline: decoding a source
+// image into an image.Image and re-encoding it already drops all EXIF
+// metadata (geolocation included), so this processor exists only to make
+// that behavior explicit and toggleable via --strip-exif.
+type StripEXIFProcessor struct{}
+
+func (StripEXIFProcessor) Process(img image.Image) (image.Image, error) {
+	return img, nil
+}
+
+// buildPipeline turns the --max-width/--max-height/--watermark/
+// --strip-exif/--convert-to flags into a configured Pipeline.
+func buildPipeline(maxWidth, maxHeight int, watermarkPath, watermarkOpacity string, stripEXIF bool, convertTo string) Pipeline {
+	var pipeline Pipeline
+
+	if maxWidth > 0 || maxHeight > 0 {
+		w, h := maxWidth, maxHeight
+		if w == 0 {
+			w = math.MaxInt32
+		}
+		if h == 0 {
+			h = math.MaxInt32
+		}
+		pipeline.Processors = append(pipeline.Processors, ResizeProcessor{MaxWidth: w, MaxHeight: h})
+	}
+
+	if watermarkPath != "" {
+		mark, err := loadWatermark(watermarkPath)
+		if err != nil {
+			log.Fatal("could not load watermark: ", err)
+		}
+		opacity, err := strconv.ParseFloat(watermarkOpacity, 64)
+		if err != nil {
+			log.Fatal("invalid --watermark-opacity: ", err)
+		}
+		pipeline.Processors = append(pipeline.Processors, WatermarkProcessor{
+			Mark:    mark,
+			Corner:  WatermarkBottomRight,
+			Opacity: opacity,
+		})
+	}
+
+	if stripEXIF {
+		pipeline.Processors = append(pipeline.Processors, StripEXIFProcessor{})
+	}
+
+	if convertTo != "" {
+		switch convertTo {
+		case "png", "jpeg":
+			pipeline.OutputFormat = convertTo
+		default:
+			log.Fatal("invalid --convert-to, must be png or jpeg")
+		}
+	}
+
+	return pipeline
+}
+
+// loadWatermark reads and decodes a watermark image from path.
+func loadWatermark(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+// decodeImage decodes path, returning the image and its detected format
+// ("png", "jpeg", "gif" or "webp").
+//
+// HEIC/HEIF is intentionally not supported: there is no cgo-free Go decoder
+// for it, so a watcher that whitelists .heic/.heif will fail clearly here
+// rather than have addFilePart silently fall back to an unconverted raw
+// upload, or fail deep inside the stdlib with an opaque "unknown format".
+func decodeImage(path string) (image.Image, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	defer f.Close()
+
+	extension := strings.ToLower(filepath.Ext(path))
+	switch extension {
+	case ".webp":
+		img, err := webp.Decode(f)
+		return img, "webp", err
+	case ".heic", ".heif":
+		return nil, "", fmt.Errorf("%s: HEIC/HEIF is not supported (no pure-Go decoder available) - convert to PNG/JPEG before uploading", path)
+	}
+
+	img, format, err := image.Decode(f)
+	return img, format, err
+}
+
+// encodeImage writes img to w in format, falling back to the source image's
+// own format when the pipeline did not request a specific OutputFormat.
+// webp has no Go encoder, so a webp source with no explicit OutputFormat is
+// written out as PNG.
+func encodeImage(w io.Writer, img image.Image, format string) error {
+	switch format {
+	case "jpeg", "jpg":
+		return jpeg.Encode(w, img, nil)
+	case "gif":
+		return gif.Encode(w, img, nil)
+	case "png", "webp", "":
+		return png.Encode(w, img)
+	default:
+		return fmt.Errorf("unsupported output format %q", format)
+	}
+}
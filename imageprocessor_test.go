@@ -0,0 +1,111 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuildPipelineEmpty(t *testing.T) {
+	p := buildPipeline(0, 0, "", "1.0", false, "")
+	if !p.Empty() {
+		t.Errorf("buildPipeline with no options should be empty, got %+v", p)
+	}
+}
+
+func TestBuildPipelineResize(t *testing.T) {
+	p := buildPipeline(800, 600, "", "1.0", false, "")
+	if p.Empty() {
+		t.Fatal("pipeline with a max width should not be empty")
+	}
+	if len(p.Processors) != 1 {
+		t.Fatalf("expected 1 processor, got %d", len(p.Processors))
+	}
+	resize, ok := p.Processors[0].(ResizeProcessor)
+	if !ok {
+		t.Fatalf("expected a ResizeProcessor, got %T", p.Processors[0])
+	}
+	if resize.MaxWidth != 800 || resize.MaxHeight != 600 {
+		t.Errorf("ResizeProcessor = %+v, want MaxWidth=800 MaxHeight=600", resize)
+	}
+}
+
+func TestBuildPipelineStripEXIF(t *testing.T) {
+	p := buildPipeline(0, 0, "", "1.0", true, "")
+	if len(p.Processors) != 1 {
+		t.Fatalf("expected 1 processor, got %d", len(p.Processors))
+	}
+	if _, ok := p.Processors[0].(StripEXIFProcessor); !ok {
+		t.Errorf("expected a StripEXIFProcessor, got %T", p.Processors[0])
+	}
+}
+
+func TestBuildPipelineConvertTo(t *testing.T) {
+	p := buildPipeline(0, 0, "", "1.0", false, "jpeg")
+	if p.OutputFormat != "jpeg" {
+		t.Errorf("OutputFormat = %q, want %q", p.OutputFormat, "jpeg")
+	}
+}
+
+func TestBuildPipelineWatermark(t *testing.T) {
+	dir := t.TempDir()
+	markPath := filepath.Join(dir, "mark.png")
+	writeTestPNG(t, markPath, 4, 4)
+
+	p := buildPipeline(0, 0, markPath, "0.5", false, "")
+	if len(p.Processors) != 1 {
+		t.Fatalf("expected 1 processor, got %d", len(p.Processors))
+	}
+	wm, ok := p.Processors[0].(WatermarkProcessor)
+	if !ok {
+		t.Fatalf("expected a WatermarkProcessor, got %T", p.Processors[0])
+	}
+	if wm.Opacity != 0.5 {
+		t.Errorf("Opacity = %v, want 0.5", wm.Opacity)
+	}
+}
+
+func TestResizeProcessorLeavesSmallImagesUntouched(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	out, err := ResizeProcessor{MaxWidth: 100, MaxHeight: 100}.Process(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Bounds() != img.Bounds() {
+		t.Errorf("ResizeProcessor resized an already-small image: %v -> %v", img.Bounds(), out.Bounds())
+	}
+}
+
+func TestResizeProcessorDownscalesPreservingAspect(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	out, err := ResizeProcessor{MaxWidth: 100, MaxHeight: 100}.Process(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := out.Bounds()
+	if b.Dx() != 100 || b.Dy() != 50 {
+		t.Errorf("resized bounds = %v, want 100x50", b)
+	}
+}
+
+// writeTestPNG writes a solid-color w x h PNG to path.
+func writeTestPNG(t *testing.T, path string, w, h int) {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{R: 255, A: 255})
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func headerResponse(headers map[string]string, body string) *http.Response {
+	h := http.Header{}
+	for k, v := range headers {
+		h.Set(k, v)
+	}
+	return &http.Response{
+		Header: h,
+		Body:   io.NopCloser(strings.NewReader(body)),
+	}
+}
+
+func TestRateLimiterUpdateAndWait(t *testing.T) {
+	rl := newRateLimiter()
+
+	resp := headerResponse(map[string]string{
+		"X-RateLimit-Remaining":   "0",
+		"X-RateLimit-Reset-After": "0.05",
+		"X-RateLimit-Bucket":      "bucket-a",
+	}, "")
+	rl.update("/webhooks/1", resp)
+
+	start := time.Now()
+	rl.wait("/webhooks/1")
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("wait() returned after %v, expected to block roughly until reset", elapsed)
+	}
+
+	// the same bucket, referenced by its X-RateLimit-Bucket id rather than
+	// the original key, should also be recognised as exhausted.
+	resp2 := headerResponse(map[string]string{
+		"X-RateLimit-Remaining":   "0",
+		"X-RateLimit-Reset-After": "0.05",
+		"X-RateLimit-Bucket":      "bucket-a",
+	}, "")
+	rl.update("/webhooks/1", resp2)
+	start = time.Now()
+	rl.wait("bucket-a")
+	if elapsed := time.Since(start); elapsed < 30*time.Millisecond {
+		t.Errorf("wait() on bucket id returned after %v, expected to block", elapsed)
+	}
+}
+
+func TestRateLimiterWaitWithoutPriorUpdateDoesNotBlock(t *testing.T) {
+	rl := newRateLimiter()
+	start := time.Now()
+	rl.wait("/webhooks/unknown")
+	if elapsed := time.Since(start); elapsed > 10*time.Millisecond {
+		t.Errorf("wait() on an unknown key blocked for %v, expected to return immediately", elapsed)
+	}
+}
+
+func TestRateLimiterUpdateIgnoresResponsesWithNoRateLimitHeaders(t *testing.T) {
+	rl := newRateLimiter()
+	rl.update("/webhooks/1", headerResponse(nil, ""))
+	if len(rl.buckets) != 0 {
+		t.Errorf("update() recorded a bucket from a response with no rate-limit headers: %v", rl.buckets)
+	}
+}
+
+func TestParseRetryAfterPrefersJSONBody(t *testing.T) {
+	resp := headerResponse(map[string]string{"Retry-After": "9"}, `{"retry_after": 1.5}`)
+	got := parseRetryAfter(resp)
+	want := 1500 * time.Millisecond
+	if got != want {
+		t.Errorf("parseRetryAfter() = %v, want %v", got, want)
+	}
+}
+
+func TestParseRetryAfterFallsBackToHeader(t *testing.T) {
+	resp := headerResponse(map[string]string{"Retry-After": "2"}, "")
+	got := parseRetryAfter(resp)
+	want := 2 * time.Second
+	if got != want {
+		t.Errorf("parseRetryAfter() = %v, want %v", got, want)
+	}
+}
+
+func TestParseRetryAfterDefaultsToOneSecond(t *testing.T) {
+	resp := headerResponse(nil, "")
+	got := parseRetryAfter(resp)
+	if got != time.Second {
+		t.Errorf("parseRetryAfter() = %v, want %v", got, time.Second)
+	}
+}
+
+func TestHeaderIntAndHeaderFloat(t *testing.T) {
+	resp := headerResponse(map[string]string{"X-RateLimit-Remaining": "5", "X-RateLimit-Reset-After": "1.25"}, "")
+
+	n, ok := headerInt(resp, "X-RateLimit-Remaining")
+	if !ok || n != 5 {
+		t.Errorf("headerInt() = (%d, %v), want (5, true)", n, ok)
+	}
+
+	f, ok := headerFloat(resp, "X-RateLimit-Reset-After")
+	if !ok || f != 1.25 {
+		t.Errorf("headerFloat() = (%v, %v), want (1.25, true)", f, ok)
+	}
+
+	if _, ok := headerInt(resp, "X-Missing"); ok {
+		t.Error("headerInt() reported ok for a missing header")
+	}
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Server exposes the daemon's runtime state over a local HTTP API, bound to
+// 127.0.0.1 only, so external tools (and the bundled status page) can see
+// what it is doing and, via /config, change it without a restart.
+type Server struct {
+	port  int
+	state *State
+	pool  *uploaderPool
+
+	configMu sync.RWMutex
+	configs  map[string]Config // keyed by watcher path
+
+	statsMu       sync.Mutex
+	lastScan      map[string]time.Time
+	queueDepth    map[string]int
+	bytesUploaded int64
+	latestVersion string
+
+	historyMu sync.Mutex
+	history   []UploadRecord
+}
+
+// UploadRecord is one entry in the server's in-memory upload history.
+type UploadRecord struct {
+	Filename  string    `json:"filename"`
+	URL       string    `json:"url"`
+	Size      int       `json:"size"`
+	Duration  float64   `json:"duration_seconds"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func newServer(config Config, state *State, watchers []Config, pool *uploaderPool) *Server {
+	configs := map[string]Config{}
+	for _, w := range watchers {
+		configs[w.path] = w
+	}
+	return &Server{
+		port:       config.port,
+		state:      state,
+		pool:       pool,
+		configs:    configs,
+		lastScan:   map[string]time.Time{},
+		queueDepth: map[string]int{},
+	}
+}
+
+// Config returns a copy of the single running watcher's config. It only
+// makes sense to call when exactly one watcher is configured (the
+// CLI-flags-only invocation); callers that may be running multiple watchers
+// (the /config and /upload HTTP handlers) must go through resolveWatcher
+// instead so they target a specific, named watcher rather than an
+// arbitrary one.
+func (s *Server) Config() Config {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+	for _, c := range s.configs {
+		return c
+	}
+	return Config{}
+}
+
+// WatcherConfig returns a copy of the currently running config for the
+// watcher at path.
+func (s *Server) WatcherConfig(path string) Config {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+	return s.configs[path]
+}
+
+// resolveWatcher picks the watcher an HTTP request targets, from its "path"
+// query parameter. With exactly one watcher configured, path may be omitted
+// and that watcher is used; with more than one, path is required, since
+// falling back to an arbitrary watcher would silently route the request
+// (and its webhook, pipeline, username) to the wrong Discord channel. On
+// failure it writes the HTTP error itself and returns ok=false.
+func (s *Server) resolveWatcher(w http.ResponseWriter, r *http.Request) (cfg Config, ok bool) {
+	s.configMu.RLock()
+	defer s.configMu.RUnlock()
+
+	path := r.URL.Query().Get("path")
+	if path != "" {
+		c, found := s.configs[path]
+		if !found {
+			http.Error(w, fmt.Sprintf("no watcher configured for path %q", path), http.StatusNotFound)
+			return Config{}, false
+		}
+		return c, true
+	}
+
+	if len(s.configs) != 1 {
+		http.Error(w, "multiple watchers are configured; specify which with ?path=", http.StatusBadRequest)
+		return Config{}, false
+	}
+	for _, c := range s.configs {
+		return c, true
+	}
+	return Config{}, false
+}
+
+// SetWatcherConfig replaces the currently running config for the watcher at
+// path, taking effect on that watcher's next scan.
+func (s *Server) SetWatcherConfig(path string, c Config) {
+	s.configMu.Lock()
+	defer s.configMu.Unlock()
+	s.configs[path] = c
+}
+
+// SetConfig replaces the running config for a single-watcher daemon. It is
+// a convenience wrapper over SetWatcherConfig for the common case.
+func (s *Server) SetConfig(c Config) {
+	s.SetWatcherConfig(c.path, c)
+}
+
+func (s *Server) recordScan(path string, queueDepth int) {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	s.lastScan[path] = time.Now()
+	s.queueDepth[path] = queueDepth
+}
+
+func (s *Server) recordUpload(rec UploadRecord) {
+	s.historyMu.Lock()
+	s.history = append(s.history, rec)
+	s.historyMu.Unlock()
+
+	s.statsMu.Lock()
+	s.bytesUploaded += int64(rec.Size)
+	s.statsMu.Unlock()
+}
+
+func (s *Server) setLatestVersion(v string) {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+	s.latestVersion = v
+}
+
+// ListenAndServe starts the control server. It blocks until the server
+// stops, which normally only happens on error.
+func (s *Server) ListenAndServe() error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleIndex)
+	mux.HandleFunc("/status", s.handleStatus)
+	mux.HandleFunc("/history", s.handleHistory)
+	mux.HandleFunc("/config", s.handleConfig)
+	mux.HandleFunc("/upload", s.handleUpload)
+
+	addr := fmt.Sprintf("127.0.0.1:%d", s.port)
+	log.Print("Control server listening on http://", addr)
+	return http.ListenAndServe(addr, mux)
+}
+
+// WatcherStatus is one watcher's entry in the /status response.
+type WatcherStatus struct {
+	Path       string    `json:"path"`
+	LastScan   time.Time `json:"last_scan"`
+	QueueDepth int       `json:"queue_depth"`
+}
+
+// StatusResponse is the JSON body returned by /status.
+type StatusResponse struct {
+	Watchers       []WatcherStatus `json:"watchers"`
+	BytesUploaded  int64           `json:"bytes_uploaded"`
+	CurrentVersion string          `json:"current_version"`
+	LatestVersion  string          `json:"latest_version"`
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	s.configMu.RLock()
+	paths := make([]string, 0, len(s.configs))
+	for p := range s.configs {
+		paths = append(paths, p)
+	}
+	s.configMu.RUnlock()
+
+	s.statsMu.Lock()
+	watchers := make([]WatcherStatus, 0, len(paths))
+	for _, p := range paths {
+		watchers = append(watchers, WatcherStatus{
+			Path:       p,
+			LastScan:   s.lastScan[p],
+			QueueDepth: s.queueDepth[p],
+		})
+	}
+	resp := StatusResponse{
+		Watchers:       watchers,
+		BytesUploaded:  s.bytesUploaded,
+		CurrentVersion: currentVersion,
+		LatestVersion:  s.latestVersion,
+	}
+	s.statsMu.Unlock()
+
+	writeJSON(w, resp)
+}
+
+func (s *Server) handleHistory(w http.ResponseWriter, r *http.Request) {
+	s.historyMu.Lock()
+	history := make([]UploadRecord, len(s.history))
+	copy(history, s.history)
+	s.historyMu.Unlock()
+
+	writeJSON(w, history)
+}
+
+// ConfigView is the JSON representation of the Config fields that can be
+// inspected and changed at runtime via /config.
+type ConfigView struct {
+	WebhookURL string `json:"webhook_url"`
+	Username   string `json:"username"`
+	Watch      int    `json:"watch"`
+}
+
+func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		cfg, ok := s.resolveWatcher(w, r)
+		if !ok {
+			return
+		}
+		writeJSON(w, ConfigView{WebhookURL: cfg.webhookURL, Username: cfg.username, Watch: cfg.watch})
+	case http.MethodPut:
+		var view ConfigView
+		if err := json.NewDecoder(r.Body).Decode(&view); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		cfg, ok := s.resolveWatcher(w, r)
+		if !ok {
+			return
+		}
+		cfg.webhookURL = view.WebhookURL
+		cfg.username = view.Username
+		cfg.watch = view.Watch
+		s.SetWatcherConfig(cfg.path, cfg)
+		writeJSON(w, view)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleUpload lets an external tool hand a file to the daemon for upload,
+// bypassing the filesystem watcher entirely.
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cfg, ok := s.resolveWatcher(w, r)
+	if !ok {
+		return
+	}
+
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	var paths []string
+	defer func() {
+		for _, path := range paths {
+			os.Remove(path)
+		}
+	}()
+
+	for _, headers := range r.MultipartForm.File {
+		for _, fh := range headers {
+			path, err := saveUploadedFile(fh)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			paths = append(paths, path)
+		}
+	}
+
+	if len(paths) == 0 {
+		http.Error(w, "no files in request", http.StatusBadRequest)
+		return
+	}
+
+	processFiles(cfg, paths, &daemon{server: s, state: s.state, pool: s.pool})
+	writeJSON(w, map[string]int{"uploaded": len(paths)})
+}
+
+func saveUploadedFile(fh *multipart.FileHeader) (string, error) {
+	src, err := fh.Open()
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	dst, err := ioutil.TempFile("", "dau-upload-*-"+filepath.Base(fh.Filename))
+	if err != nil {
+		return "", err
+	}
+	defer dst.Close()
+
+	if _, err := io.Copy(dst, src); err != nil {
+		os.Remove(dst.Name())
+		return "", err
+	}
+	return dst.Name(), nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Print("could not write JSON response: ", err)
+	}
+}
+
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.WriteString(w, indexPage)
+}
+
+const indexPage = `<!DOCTYPE html>
+<html>
+<head>
+<title>discord-auto-upload</title>
+<meta charset="utf-8">
+</head>
+<body>
+<h1>discord-auto-upload</h1>
+<pre id="status">loading...</pre>
+<h2>History</h2>
+<ul id="history"></ul>
+<script>
+async function refresh() {
+  const status = await (await fetch('/status')).json();
+  document.getElementById('status').textContent = JSON.stringify(status, null, 2);
+
+  const history = await (await fetch('/history')).json();
+  const list = document.getElementById('history');
+  list.innerHTML = '';
+  (history || []).slice().reverse().forEach(function (item) {
+    const li = document.createElement('li');
+    li.textContent = item.timestamp + ' - ' + item.filename + ' -> ' + item.url;
+    list.appendChild(li);
+  });
+}
+refresh();
+setInterval(refresh, 5000);
+</script>
+</body>
+</html>
+`
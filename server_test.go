@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestServer(watchers ...Config) *Server {
+	return newServer(Config{}, nil, watchers, newUploaderPool())
+}
+
+func TestHandleConfigRequiresPathWithMultipleWatchers(t *testing.T) {
+	s := newTestServer(
+		Config{path: "/tmp/a", webhookURL: "http://example.com/a"},
+		Config{path: "/tmp/b", webhookURL: "http://example.com/b"},
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/config", nil)
+	rec := httptest.NewRecorder()
+	s.handleConfig(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestHandleConfigUnknownPath404s(t *testing.T) {
+	s := newTestServer(
+		Config{path: "/tmp/a", webhookURL: "http://example.com/a"},
+		Config{path: "/tmp/b", webhookURL: "http://example.com/b"},
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/config?path=/tmp/missing", nil)
+	rec := httptest.NewRecorder()
+	s.handleConfig(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestHandleConfigResolvesNamedWatcher(t *testing.T) {
+	s := newTestServer(
+		Config{path: "/tmp/a", webhookURL: "http://example.com/a", username: "alice"},
+		Config{path: "/tmp/b", webhookURL: "http://example.com/b", username: "bob"},
+	)
+
+	req := httptest.NewRequest(http.MethodGet, "/config?path=/tmp/b", nil)
+	rec := httptest.NewRecorder()
+	s.handleConfig(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d (body: %s)", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	var view ConfigView
+	if err := json.Unmarshal(rec.Body.Bytes(), &view); err != nil {
+		t.Fatal(err)
+	}
+	if view.WebhookURL != "http://example.com/b" || view.Username != "bob" {
+		t.Errorf("resolved watcher = %+v, want b's config", view)
+	}
+}
+
+func TestHandleConfigSingleWatcherOmittedPathOK(t *testing.T) {
+	s := newTestServer(Config{path: "/tmp/a", webhookURL: "http://example.com/a"})
+
+	req := httptest.NewRequest(http.MethodGet, "/config", nil)
+	rec := httptest.NewRecorder()
+	s.handleConfig(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d (body: %s)", rec.Code, http.StatusOK, rec.Body.String())
+	}
+}
+
+func TestHandleUploadRequiresPathWithMultipleWatchers(t *testing.T) {
+	s := newTestServer(
+		Config{path: "/tmp/a", webhookURL: "http://example.com/a"},
+		Config{path: "/tmp/b", webhookURL: "http://example.com/b"},
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", nil)
+	rec := httptest.NewRecorder()
+	s.handleUpload(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestHandleUploadUnknownPath404s(t *testing.T) {
+	s := newTestServer(
+		Config{path: "/tmp/a", webhookURL: "http://example.com/a"},
+		Config{path: "/tmp/b", webhookURL: "http://example.com/b"},
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/upload?path=/tmp/missing", nil)
+	rec := httptest.NewRecorder()
+	s.handleUpload(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// UploadedFileRecord is what State remembers about a single uploaded file,
+// enough to render /history and to recognise the file if it is seen again.
+type UploadedFileRecord struct {
+	Path       string    `json:"path"`
+	ModTime    time.Time `json:"mod_time"`
+	Size       int64     `json:"size"`
+	URL        string    `json:"url"`
+	Width      int       `json:"width"`
+	Height     int       `json:"height"`
+	MessageID  int64     `json:"message_id"`
+	UploadedAt time.Time `json:"uploaded_at"`
+}
+
+// State is the on-disk record of watcher progress: the last scan time per
+// watched path, and the set of files already uploaded (keyed by absolute
+// path, mtime and size) so a restart neither re-uploads nor misses files.
+type State struct {
+	mu   sync.Mutex
+	path string
+
+	LastCheck map[string]time.Time          `json:"last_check"`
+	Uploads   map[string]UploadedFileRecord `json:"uploads"`
+}
+
+// defaultStatePath returns the platform-appropriate default state file
+// location: $XDG_STATE_HOME/dau/state.json on Linux, with equivalents for
+// macOS and Windows.
+func defaultStatePath() string {
+	switch runtime.GOOS {
+	case "windows":
+		if dir := os.Getenv("LOCALAPPDATA"); dir != "" {
+			return filepath.Join(dir, "dau", "state.json")
+		}
+		home, _ := os.UserHomeDir()
+		return filepath.Join(home, "AppData", "Local", "dau", "state.json")
+	case "darwin":
+		home, _ := os.UserHomeDir()
+		return filepath.Join(home, "Library", "Application Support", "dau", "state.json")
+	default:
+		if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+			return filepath.Join(dir, "dau", "state.json")
+		}
+		home, _ := os.UserHomeDir()
+		return filepath.Join(home, ".local", "state", "dau", "state.json")
+	}
+}
+
+// loadState reads path, returning a freshly-initialised State if it does
+// not yet exist.
+func loadState(path string) (*State, error) {
+	state := &State{
+		path:      path,
+		LastCheck: map[string]time.Time{},
+		Uploads:   map[string]UploadedFileRecord{},
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return state, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	state.path = path
+	if state.LastCheck == nil {
+		state.LastCheck = map[string]time.Time{}
+	}
+	if state.Uploads == nil {
+		state.Uploads = map[string]UploadedFileRecord{}
+	}
+
+	return state, nil
+}
+
+// resetState removes the state file at path, if present.
+func resetState(path string) error {
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// LastCheckFor returns the last recorded scan time for watchPath.
+func (s *State) LastCheckFor(watchPath string) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.LastCheck[watchPath]
+	return t, ok
+}
+
+// SetLastCheck records the last scan time for watchPath and persists it.
+func (s *State) SetLastCheck(watchPath string, t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.LastCheck[watchPath] = t
+	return s.saveLocked()
+}
+
+// Seen reports whether the file at path, with the given mtime and size,
+// has already been uploaded.
+func (s *State) Seen(path string, modTime time.Time, size int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.Uploads[uploadKey(path, modTime, size)]
+	return ok
+}
+
+// RecordUpload stores rec under path+modTime+size and persists it.
+func (s *State) RecordUpload(path string, modTime time.Time, size int64, rec UploadedFileRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Uploads[uploadKey(path, modTime, size)] = rec
+	return s.saveLocked()
+}
+
+// Seed marks the file at path, with the given mtime and size, as already
+// uploaded without an associated upload record, so a watcher's first scan
+// of a directory that already contains files doesn't treat them as new
+// arrivals. It is a no-op if the file is already recorded.
+func (s *State) Seed(path string, modTime time.Time, size int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := uploadKey(path, modTime, size)
+	if _, ok := s.Uploads[key]; ok {
+		return nil
+	}
+	s.Uploads[key] = UploadedFileRecord{Path: path, ModTime: modTime, Size: size}
+	return s.saveLocked()
+}
+
+func uploadKey(path string, modTime time.Time, size int64) string {
+	return fmt.Sprintf("%s|%d|%d", path, modTime.UnixNano(), size)
+}
+
+// saveLocked writes the state to disk and fsyncs it. Callers must hold s.mu.
+func (s *State) saveLocked() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+func absPath(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return abs
+}
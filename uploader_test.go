@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image/png"
+	"io"
+	"mime"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewMessageUploadRequestPartOrder(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.png")
+	b := filepath.Join(dir, "b.png")
+	if err := os.WriteFile(a, []byte("file-a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(b, []byte("file-b"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := DiscordMessage{Content: "new screenshot"}
+	req, err := newMessageUploadRequest("http://example.com/hook", msg, []string{a, b}, Pipeline{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, params, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr := multipart.NewReader(req.Body, params["boundary"])
+
+	part, err := mr.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if part.FormName() != "payload_json" {
+		t.Fatalf("first part = %q, want payload_json", part.FormName())
+	}
+	payload, err := io.ReadAll(part)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gotMsg DiscordMessage
+	if err := json.Unmarshal(payload, &gotMsg); err != nil {
+		t.Fatal(err)
+	}
+	if gotMsg.Content != msg.Content {
+		t.Errorf("payload_json content = %q, want %q", gotMsg.Content, msg.Content)
+	}
+
+	wantFiles := []struct {
+		name string
+		body string
+	}{
+		{"files[0]", "file-a"},
+		{"files[1]", "file-b"},
+	}
+	for _, want := range wantFiles {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatalf("reading part %q: %v", want.name, err)
+		}
+		if part.FormName() != want.name {
+			t.Errorf("part name = %q, want %q", part.FormName(), want.name)
+		}
+		body, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != want.body {
+			t.Errorf("part %q body = %q, want %q", want.name, body, want.body)
+		}
+	}
+
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("expected exactly 3 parts, got an extra one (err=%v)", err)
+	}
+}
+
+func TestUploadRejectsTooManyFiles(t *testing.T) {
+	paths := make([]string, maxAttachmentsPerMessage+1)
+	for i := range paths {
+		paths[i] = filepath.Join(t.TempDir(), "x.png")
+	}
+
+	u := newUploader("http://example.com/hook", defaultMaxRetryAttempts)
+	if _, err := u.Upload(paths, DiscordMessage{}, Pipeline{}); err == nil {
+		t.Error("expected an error uploading more than maxAttachmentsPerMessage files")
+	}
+}
+
+func TestAddFilePartRawPassthrough(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "shot.png")
+	want := []byte("not actually a png, just raw bytes")
+	if err := os.WriteFile(path, want, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	if err := addFilePart(writer, 0, path, Pipeline{}); err != nil {
+		t.Fatal(err)
+	}
+	writer.Close()
+
+	_, params, err := mime.ParseMediaType("multipart/form-data; boundary=" + writer.Boundary())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr := multipart.NewReader(body, params["boundary"])
+	part, err := mr.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if part.FormName() != "files[0]" || part.FileName() != "shot.png" {
+		t.Errorf("part = %q/%q, want files[0]/shot.png", part.FormName(), part.FileName())
+	}
+	got, err := io.ReadAll(part)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("raw passthrough altered file content: got %q, want %q", got, want)
+	}
+}
+
+func TestAddFilePartDecodesAndProcessesWhenPipelineNonEmpty(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "shot.png")
+	writeTestPNG(t, path, 10, 10)
+
+	pipeline := Pipeline{Processors: []ImageProcessor{ResizeProcessor{MaxWidth: 1, MaxHeight: 1}}}
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	if err := addFilePart(writer, 0, path, pipeline); err != nil {
+		t.Fatal(err)
+	}
+	writer.Close()
+
+	_, params, err := mime.ParseMediaType("multipart/form-data; boundary=" + writer.Boundary())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr := multipart.NewReader(body, params["boundary"])
+	part, err := mr.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := png.Decode(part)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 1 || b.Dy() != 1 {
+		t.Errorf("processed image bounds = %v, want 1x1", b)
+	}
+}
+
+func TestAddFilePartDecodesWebpEvenWithEmptyPipeline(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "shot.webp")
+	// not a real webp file, but enough to prove the webp decode path (not
+	// the raw passthrough path) is the one that rejects it.
+	if err := os.WriteFile(path, []byte("not a real webp"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	err := addFilePart(writer, 0, path, Pipeline{})
+	if err == nil {
+		t.Fatal("expected an error decoding a bogus webp file")
+	}
+}
+
+func TestAddFilePartRejectsHEIC(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "shot.heic")
+	if err := os.WriteFile(path, []byte("irrelevant"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	err := addFilePart(writer, 0, path, Pipeline{})
+	if err == nil {
+		t.Fatal("expected an error attaching a HEIC file")
+	}
+}